@@ -0,0 +1,185 @@
+package campaign
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Campaign statuses. A campaign starts Pending, moves to Sending once the
+// worker picks it up, and ends at either Sent or Cancelled.
+const (
+	StatusPending   = "pending"
+	StatusSending   = "sending"
+	StatusSent      = "sent"
+	StatusCancelled = "cancelled"
+)
+
+type Campaign struct {
+	Id          int64
+	Subject     string
+	Body        string
+	CreatedAt   time.Time
+	ScheduledAt time.Time
+	Status      string
+}
+
+func campaignFromRow(row *sql.Rows) (*Campaign, error) {
+	var id int64
+	var subject string
+	var body string
+	var createdAt int64
+	var scheduledAt int64
+	var status string
+
+	err := row.Scan(&id, &subject, &body, &createdAt, &scheduledAt, &status)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &Campaign{
+		Id:          id,
+		Subject:     subject,
+		Body:        body,
+		CreatedAt:   time.Unix(createdAt, 0),
+		ScheduledAt: time.Unix(scheduledAt, 0),
+		Status:      status,
+	}, nil
+}
+
+// CreateCampaign schedules a new campaign for delivery at scheduledAt.
+func CreateCampaign(db *sql.DB, subject string, body string, scheduledAt time.Time) error {
+	_, err := db.Exec(`INSERT INTO
+		campaigns(subject, body, created_at, scheduled_at, status)
+		VALUES(?, ?, ?, ?, ?)`, subject, body, time.Now().Unix(), scheduledAt.Unix(), StatusPending)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// ListCampaigns returns every campaign, most recently created first.
+func ListCampaigns(db *sql.DB) ([]Campaign, error) {
+	rows, err := db.Query(`
+		SELECT id, subject, body, created_at, scheduled_at, status
+		FROM campaigns
+		ORDER BY id DESC`)
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var campaigns []Campaign
+	for rows.Next() {
+		c, err := campaignFromRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, *c)
+	}
+
+	return campaigns, nil
+}
+
+// CancelCampaign marks a pending campaign as cancelled so the worker skips
+// it. It has no effect on a campaign that's already sending or sent.
+func CancelCampaign(db *sql.DB, id int64) error {
+	_, err := db.Exec(`
+		UPDATE campaigns
+		SET status=?
+		WHERE id=? AND status=?`, StatusCancelled, id, StatusPending)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
+
+// DueCampaigns returns pending campaigns whose scheduled_at has passed,
+// plus any campaign still marked Sending, for the worker to pick up. A
+// campaign is only ever moved to Sending once the worker has started
+// delivering it, so finding one still in that state on a poll means the
+// previous run crashed partway through; re-selecting it here is what lets
+// the worker resume from campaign_deliveries instead of leaving it stuck.
+func DueCampaigns(db *sql.DB, now time.Time) ([]Campaign, error) {
+	rows, err := db.Query(`
+		SELECT id, subject, body, created_at, scheduled_at, status
+		FROM campaigns
+		WHERE status = ? OR (status = ? AND scheduled_at <= ?)
+		ORDER BY scheduled_at ASC`, StatusSending, StatusPending, now.Unix())
+
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var campaigns []Campaign
+	for rows.Next() {
+		c, err := campaignFromRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, *c)
+	}
+
+	return campaigns, nil
+}
+
+// SetCampaignStatus transitions a campaign to a new status, e.g. from
+// Pending to Sending when the worker starts on it, or to Sent once every
+// recipient has been handled.
+func SetCampaignStatus(db *sql.DB, id int64, status string) error {
+	_, err := db.Exec(`UPDATE campaigns SET status=? WHERE id=?`, status, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// HasDelivered reports whether a campaign has already been successfully
+// sent to email, so a restarted worker doesn't double-send.
+func HasDelivered(db *sql.DB, campaignId int64, email string) (bool, error) {
+	rows, err := db.Query(`
+		SELECT sent_at FROM campaign_deliveries
+		WHERE campaign_id=? AND email=? AND sent_at IS NOT NULL AND sent_at != 0`, campaignId, email)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	defer rows.Close()
+
+	return rows.Next(), nil
+}
+
+// RecordDelivery upserts the outcome of a send attempt for one recipient.
+// sentAt is zero and lastErr is non-empty for a failed attempt.
+func RecordDelivery(db *sql.DB, campaignId int64, email string, sentAt time.Time, lastErr string) error {
+	var sentAtUnix int64
+	if !sentAt.IsZero() {
+		sentAtUnix = sentAt.Unix()
+	}
+
+	_, err := db.Exec(`INSERT INTO
+		campaign_deliveries(campaign_id, email, sent_at, last_error)
+		VALUES(?, ?, ?, ?)
+		ON CONFLICT(campaign_id, email) DO UPDATE SET
+			sent_at=excluded.sent_at,
+			last_error=excluded.last_error`, campaignId, email, sentAtUnix, lastErr)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package campaign
+
+import (
+	"log"
+	"net/smtp"
+)
+
+// LogMailer logs outgoing campaign messages instead of sending them. It's
+// the default Mailer used when no SMTP settings are configured, and is
+// what tests should use.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(toEmail, subject, body string) error {
+	log.Printf("campaign email to '%v': subject=%q body=%q\n", toEmail, subject, body)
+	return nil
+}
+
+// SMTPMailer sends campaign messages through a real SMTP relay.
+type SMTPMailer struct {
+	Addr string // host:port of the SMTP server
+	From string
+	auth smtp.Auth
+}
+
+func NewSMTPMailer(addr, from, username, password, host string) *SMTPMailer {
+	return &SMTPMailer{
+		Addr: addr,
+		From: from,
+		auth: smtp.PlainAuth("", username, password, host),
+	}
+}
+
+func (m *SMTPMailer) Send(toEmail, subject, body string) error {
+	msg := "From: " + m.From + "\r\nTo: " + toEmail + "\r\nSubject: " + subject + "\r\n\r\n" + body
+
+	if err := smtp.SendMail(m.Addr, m.auth, m.From, []string{toEmail}, []byte(msg)); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package campaign
+
+import (
+	"database/sql"
+	"embed"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const migrationsTable = "campaign_migrations"
+
+// Migrate applies any pending campaign-schema migrations, recording
+// applied versions in the campaign_migrations table. It's safe to call on
+// every startup: migrations that have already run are skipped.
+func Migrate(db *sql.DB) error {
+	migrate.SetTable(migrationsTable)
+
+	source := migrate.EmbedFileSystemMigrationSource{
+		FileSystem: migrationFiles,
+		Root:       "migrations",
+	}
+
+	_, err := migrate.Exec(db, "sqlite3", source, migrate.Up)
+	return err
+}
@@ -0,0 +1,157 @@
+package campaign
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mailinglist/mdb"
+	"net/textproto"
+	"text/template"
+	"time"
+)
+
+// Mailer sends one rendered campaign message. It's distinct from
+// jsonapi.Mailer because campaigns send an arbitrary subject/body rather
+// than a fixed confirmation email.
+type Mailer interface {
+	Send(toEmail, subject, body string) error
+}
+
+// maxAttempts bounds the retry/backoff applied to a single recipient
+// before its failure is recorded and the worker moves on; a recipient that
+// keeps failing shouldn't be able to stall an entire campaign.
+const maxAttempts = 3
+
+type templateData struct {
+	Email    string
+	UnsubURL string
+}
+
+// StartWorker polls for due campaigns every pollInterval and sends them to
+// every confirmed, non-opted-out subscriber through mailer, no faster than
+// ratePerSecond messages per second. It never returns; call it in its own
+// goroutine.
+func StartWorker(db *sql.DB, mailer Mailer, baseURL string, ratePerSecond float64, pollInterval time.Duration) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := runDueCampaigns(db, mailer, baseURL, ratePerSecond); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func runDueCampaigns(db *sql.DB, mailer Mailer, baseURL string, ratePerSecond float64) error {
+	due, err := DueCampaigns(db, time.Now())
+	if err != nil {
+		return err
+	}
+
+	for _, c := range due {
+		if err := sendCampaign(db, mailer, baseURL, ratePerSecond, c); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return nil
+}
+
+func sendCampaign(db *sql.DB, mailer Mailer, baseURL string, ratePerSecond float64, c Campaign) error {
+	tmpl, err := template.New("campaign").Parse(c.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := SetCampaignStatus(db, c.Id, StatusSending); err != nil {
+		return err
+	}
+
+	it, closeIt, err := mdb.IterateEmails(db, mdb.EmailFilter{})
+	if err != nil {
+		return err
+	}
+	defer closeIt()
+
+	var minInterval time.Duration
+	if ratePerSecond > 0 {
+		minInterval = time.Duration(float64(time.Second) / ratePerSecond)
+	}
+
+	for {
+		entry, err := it()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := deliverWithRetry(db, mailer, baseURL, tmpl, c, *entry); err != nil {
+			log.Println(err)
+		}
+
+		if minInterval > 0 {
+			time.Sleep(minInterval)
+		}
+	}
+
+	return SetCampaignStatus(db, c.Id, StatusSent)
+}
+
+// deliverWithRetry sends one message, retrying transient SMTP errors with
+// exponential backoff up to maxAttempts, and records the outcome so a
+// crashed process can resume the campaign without double-sending.
+func deliverWithRetry(db *sql.DB, mailer Mailer, baseURL string, tmpl *template.Template, c Campaign, entry mdb.EmailEntry) error {
+	delivered, err := HasDelivered(db, c.Id, entry.Email)
+	if err != nil {
+		return err
+	}
+	if delivered {
+		return nil
+	}
+
+	var body bytes.Buffer
+	data := templateData{
+		Email:    entry.Email,
+		UnsubURL: fmt.Sprintf("%v/unsubscribe?token=%v", baseURL, entry.UnsubToken),
+	}
+	if err := tmpl.Execute(&body, data); err != nil {
+		return RecordDelivery(db, c.Id, entry.Email, time.Time{}, err.Error())
+	}
+
+	backoff := time.Second
+	var sendErr error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		sendErr = mailer.Send(entry.Email, c.Subject, body.String())
+		if sendErr == nil {
+			return RecordDelivery(db, c.Id, entry.Email, time.Now(), "")
+		}
+
+		log.Printf("campaign %v: attempt %v to send to '%v' failed: %v\n", c.Id, attempt+1, entry.Email, sendErr)
+		if isPermanentSMTPError(sendErr) {
+			break
+		}
+		if attempt < maxAttempts-1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	return RecordDelivery(db, c.Id, entry.Email, time.Time{}, sendErr.Error())
+}
+
+// isPermanentSMTPError reports whether err is a 5xx SMTP reply, which
+// retrying won't fix (e.g. unknown recipient, bad auth). Anything else -
+// a 4xx reply or a network-level error - is treated as transient and
+// worth retrying.
+func isPermanentSMTPError(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 500 && protoErr.Code < 600
+	}
+	return false
+}
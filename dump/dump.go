@@ -0,0 +1,52 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"mailinglist/mdb"
+
+	"github.com/alexflint/go-arg"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var args struct {
+	DbPath             string `arg:"env:MAILINGLIST_DB"`
+	IncludeUnconfirmed bool   `arg:"--unconfirmed" help:"include subscribers that haven't confirmed yet"`
+	IncludeOptedOut    bool   `arg:"--opted-out" help:"include subscribers that have opted out"`
+}
+
+func main() {
+	arg.MustParse(&args)
+
+	if args.DbPath == "" {
+		args.DbPath = "list.db" // default DB location
+	}
+
+	db, err := sql.Open("sqlite3", args.DbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	it, closeIt, err := mdb.IterateEmails(db, mdb.EmailFilter{
+		IncludeUnconfirmed: args.IncludeUnconfirmed,
+		IncludeOptedOut:    args.IncludeOptedOut,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeIt()
+
+	for {
+		entry, err := it()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(entry.Email)
+	}
+}
@@ -0,0 +1,94 @@
+package jsonapi
+
+import (
+	"database/sql"
+	"mailinglist/campaign"
+	"net/http"
+	"time"
+)
+
+type CreateCampaignRequest struct {
+	Subject     string `json:"subject"`
+	Body        string `json:"body"`
+	ScheduledAt int64  `json:"scheduled_at"` // unix time; 0 means send as soon as possible
+}
+
+type CancelCampaignRequest struct {
+	Id int64 `json:"id"`
+}
+
+type CampaignResponse struct {
+	Id          int64  `json:"id"`
+	Subject     string `json:"subject"`
+	Body        string `json:"body"`
+	CreatedAt   int64  `json:"created_at"`
+	ScheduledAt int64  `json:"scheduled_at"`
+	Status      string `json:"status"`
+}
+
+func newCampaignResponse(c *campaign.Campaign) CampaignResponse {
+	return CampaignResponse{
+		Id:          c.Id,
+		Subject:     c.Subject,
+		Body:        c.Body,
+		CreatedAt:   c.CreatedAt.Unix(),
+		ScheduledAt: c.ScheduledAt.Unix(),
+		Status:      c.Status,
+	}
+}
+
+func createCampaign(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request CreateCampaignRequest
+		if err := fromJson(r.Body, &request); err != nil {
+			returnErr(w, err, http.StatusBadRequest)
+			return
+		}
+
+		scheduledAt := time.Now()
+		if request.ScheduledAt != 0 {
+			scheduledAt = time.Unix(request.ScheduledAt, 0)
+		}
+
+		if err := campaign.CreateCampaign(db, request.Subject, request.Body, scheduledAt); err != nil {
+			returnErr(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func listCampaigns(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		returnJson(w, func() (interface{}, error) {
+			campaigns, err := campaign.ListCampaigns(db)
+			if err != nil {
+				return nil, err
+			}
+
+			responses := make([]CampaignResponse, 0, len(campaigns))
+			for _, c := range campaigns {
+				responses = append(responses, newCampaignResponse(&c))
+			}
+			return responses, nil
+		})
+	}
+}
+
+func cancelCampaign(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request CancelCampaignRequest
+		if err := fromJson(r.Body, &request); err != nil {
+			returnErr(w, err, http.StatusBadRequest)
+			return
+		}
+
+		if err := campaign.CancelCampaign(db, request.Id); err != nil {
+			returnErr(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
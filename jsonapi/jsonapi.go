@@ -0,0 +1,227 @@
+package jsonapi
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"mailinglist/mdb"
+	"net/http"
+)
+
+type CreateEmailRequest struct {
+	Email string `json:"email"`
+}
+
+type EmailResponse struct {
+	Id          int64  `json:"id"`
+	Email       string `json:"email"`
+	ConfirmedAt int64  `json:"confirmed_at"`
+	OptOut      bool   `json:"opt_out"`
+}
+
+func newEmailResponse(entry *mdb.EmailEntry) EmailResponse {
+	return EmailResponse{
+		Id:          entry.Id,
+		Email:       entry.Email,
+		ConfirmedAt: entry.ConfirmedAt.Unix(),
+		OptOut:      entry.OptOut,
+	}
+}
+
+type GetEmailBatchRequest struct {
+	Page  int `json:"page"`
+	Count int `json:"count"`
+}
+
+func fromJson(body io.Reader, target interface{}) error {
+	decoder := json.NewDecoder(body)
+	return decoder.Decode(target)
+}
+
+func returnJson(w http.ResponseWriter, withData func() (interface{}, error)) {
+	data, err := withData()
+
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	jsonBytes, err := json.Marshal(data)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func returnErr(w http.ResponseWriter, err error, code int) {
+	log.Println(err)
+	w.WriteHeader(code)
+	w.Write([]byte(err.Error()))
+}
+
+// createEmail inserts a new, unconfirmed subscriber and dispatches the
+// opt-in confirmation email containing their sub/unsub links. Re-submitting
+// a pending address re-sends the confirmation instead of failing, and
+// re-submitting an already-confirmed one is reported back without sending
+// any mail.
+func createEmail(db *sql.DB, mailer Mailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request CreateEmailRequest
+		if err := fromJson(r.Body, &request); err != nil {
+			returnErr(w, err, http.StatusBadRequest)
+			return
+		}
+
+		message := "confirmation email sent"
+
+		err := mdb.CreateEmail(db, request.Email)
+		switch err {
+		case nil, mdb.ErrPendingConfirmation:
+			if err == mdb.ErrPendingConfirmation {
+				message = "confirmation re-sent"
+			}
+		case mdb.ErrAlreadyConfirmed:
+			message = "already confirmed"
+		case mdb.ErrInvalidEmail:
+			returnErr(w, err, http.StatusBadRequest)
+			return
+		default:
+			returnErr(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		entry, getErr := mdb.GetEmail(db, request.Email)
+		if getErr != nil {
+			returnErr(w, getErr, http.StatusInternalServerError)
+			return
+		}
+
+		if err != mdb.ErrAlreadyConfirmed {
+			if sendErr := mailer.SendConfirmation(entry.Email, entry.SubToken, entry.UnsubToken); sendErr != nil {
+				returnErr(w, sendErr, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		returnJson(w, func() (interface{}, error) {
+			return map[string]interface{}{
+				"message": message,
+				"email":   newEmailResponse(entry),
+			}, nil
+		})
+	}
+}
+
+func getEmail(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Email string `json:"email"`
+		}
+		if err := fromJson(r.Body, &request); err != nil {
+			returnErr(w, err, http.StatusBadRequest)
+			return
+		}
+
+		returnJson(w, func() (interface{}, error) {
+			entry, err := mdb.GetEmail(db, request.Email)
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil {
+				return nil, nil
+			}
+			return newEmailResponse(entry), nil
+		})
+	}
+}
+
+func getEmailBatch(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request GetEmailBatchRequest
+		if err := fromJson(r.Body, &request); err != nil {
+			returnErr(w, err, http.StatusBadRequest)
+			return
+		}
+
+		returnJson(w, func() (interface{}, error) {
+			params := mdb.GetEmailBatchQueryParams{Page: request.Page, Count: request.Count}
+			entries, err := mdb.GetEmailBatch(db, params)
+			if err != nil {
+				return nil, err
+			}
+
+			responses := make([]EmailResponse, 0, len(entries))
+			for _, entry := range entries {
+				responses = append(responses, newEmailResponse(&entry))
+			}
+			return responses, nil
+		})
+	}
+}
+
+// confirmEmail handles the link a subscriber clicks from their opt-in
+// email, marking their entry confirmed.
+func confirmEmail(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			returnErr(w, io.ErrUnexpectedEOF, http.StatusBadRequest)
+			return
+		}
+
+		err := mdb.ConfirmEmail(db, token)
+		switch err {
+		case nil:
+			w.Write([]byte("you're confirmed!"))
+		case mdb.ErrTokenNotFound:
+			returnErr(w, err, http.StatusNotFound)
+		default:
+			returnErr(w, err, http.StatusInternalServerError)
+		}
+	}
+}
+
+// unsubscribeEmail handles the unsubscribe link mailed alongside every
+// confirmation and campaign message.
+func unsubscribeEmail(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			returnErr(w, io.ErrUnexpectedEOF, http.StatusBadRequest)
+			return
+		}
+
+		err := mdb.UnsubscribeByToken(db, token)
+		switch err {
+		case nil:
+			w.Write([]byte("you've been unsubscribed"))
+		case mdb.ErrTokenNotFound:
+			returnErr(w, err, http.StatusNotFound)
+		default:
+			returnErr(w, err, http.StatusInternalServerError)
+		}
+	}
+}
+
+func Serve(db *sql.DB, bind string, mailer Mailer) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/email/create", createEmail(db, mailer))
+	mux.HandleFunc("/email/get", getEmail(db))
+	mux.HandleFunc("/email/getBatch", getEmailBatch(db))
+	mux.HandleFunc("/confirm", confirmEmail(db))
+	mux.HandleFunc("/unsubscribe", unsubscribeEmail(db))
+	mux.HandleFunc("/campaign/create", createCampaign(db))
+	mux.HandleFunc("/campaign/list", listCampaigns(db))
+	mux.HandleFunc("/campaign/cancel", cancelCampaign(db))
+
+	log.Printf("json api server listening on %v\n", bind)
+	if err := http.ListenAndServe(bind, mux); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,74 @@
+package jsonapi
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+)
+
+// Mailer dispatches the confirmation email a new subscriber needs to click
+// before they start receiving mail. It is pluggable so tests and local
+// development don't need a real SMTP server.
+type Mailer interface {
+	SendConfirmation(toEmail, subToken, unsubToken string) error
+}
+
+// LogMailer logs the confirmation/unsubscribe links instead of sending
+// anything. It's the default Mailer used when no SMTP settings are
+// configured, and is what tests should use.
+type LogMailer struct {
+	BaseURL string
+}
+
+func NewLogMailer(baseURL string) *LogMailer {
+	return &LogMailer{BaseURL: baseURL}
+}
+
+func (m *LogMailer) SendConfirmation(toEmail, subToken, unsubToken string) error {
+	log.Printf(
+		"confirmation email for '%v': confirm=%v unsubscribe=%v\n",
+		toEmail, confirmURL(m.BaseURL, subToken), unsubscribeURL(m.BaseURL, unsubToken),
+	)
+	return nil
+}
+
+// SMTPMailer sends the confirmation email through a real SMTP relay.
+type SMTPMailer struct {
+	Addr    string // host:port of the SMTP server
+	From    string
+	BaseURL string // public base URL the confirm/unsubscribe links are built on
+	auth    smtp.Auth
+}
+
+func NewSMTPMailer(addr, from, baseURL, username, password, host string) *SMTPMailer {
+	return &SMTPMailer{
+		Addr:    addr,
+		From:    from,
+		BaseURL: baseURL,
+		auth:    smtp.PlainAuth("", username, password, host),
+	}
+}
+
+func (m *SMTPMailer) SendConfirmation(toEmail, subToken, unsubToken string) error {
+	subject := "Confirm your subscription"
+	body := fmt.Sprintf(
+		"Please confirm your subscription by visiting:\n%v\n\n"+
+			"If you didn't ask to subscribe, you can ignore this email, or unsubscribe here:\n%v\n",
+		confirmURL(m.BaseURL, subToken), unsubscribeURL(m.BaseURL, unsubToken),
+	)
+	msg := fmt.Sprintf("From: %v\r\nTo: %v\r\nSubject: %v\r\n\r\n%v", m.From, toEmail, subject, body)
+
+	if err := smtp.SendMail(m.Addr, m.auth, m.From, []string{toEmail}, []byte(msg)); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func confirmURL(baseURL, subToken string) string {
+	return fmt.Sprintf("%v/confirm?token=%v", baseURL, subToken)
+}
+
+func unsubscribeURL(baseURL, unsubToken string) string {
+	return fmt.Sprintf("%v/unsubscribe?token=%v", baseURL, unsubToken)
+}
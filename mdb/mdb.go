@@ -1,40 +1,57 @@
 package mdb
 
 import (
+	"crypto/rand"
+	"crypto/sha512"
 	"database/sql"
+	"encoding/base64"
+	"errors"
+	"io"
 	"log"
+	"net/mail"
 	"time"
-
-	"github.com/mattn/go-sqlite3"
 )
 
+// ErrPendingConfirmation is returned by CreateEmail when the address is
+// already on the list but hasn't confirmed yet: the sub-token has been
+// rotated and the caller should re-send the confirmation email.
+var ErrPendingConfirmation = errors.New("mdb: email already registered, pending confirmation")
+
+// ErrAlreadyConfirmed is returned by CreateEmail when the address is
+// already on the list and has already confirmed.
+var ErrAlreadyConfirmed = errors.New("mdb: email already confirmed")
+
+// ErrInvalidEmail is returned by CreateEmail when the address isn't a
+// single, well-formed RFC 5322 mailbox. Rejecting it here also keeps
+// stray CR/LF bytes out of the table, so a Mailer can safely use the
+// stored address in a raw SMTP header.
+var ErrInvalidEmail = errors.New("mdb: invalid email address")
+
+// ErrTokenNotFound is returned by ConfirmEmail and UnsubscribeByToken when
+// no entry has the given sub/unsub token, so a caller can distinguish a
+// stale or forged link from a successful confirmation/unsubscribe.
+var ErrTokenNotFound = errors.New("mdb: token not found")
+
 type EmailEntry struct {
 	Id						int64
 	Email					string
 	ConfirmedAt				*time.Time
 	OptOut					bool
+	SubToken				string
+	UnsubToken				string
 }
 
-func TryCreate(db *sql.DB) {
-	_, err := db.Exec(`
-		CREATE TABLE emails (
-			id				INTERGER PRIMARY KEY,
-			email			TEXT UNIQUE,
-			confirmed_at	INTEGER,
-			opt_out			INTEGER
-		)
-	`)
-	if err != nil {
-		// 'err.(sqlite3.Error)' means the error is being casted to a sqlite3 error
-		if sqlError, ok := err.(sqlite3.Error); ok {
-			// code 1 == "table already exists"
-			if sqlError.Code != 1 {
-				log.Fatal(sqlError)
-			}
-		} else {
-			log.Fatal(err)
-		}
+// generateToken returns a random, URL-safe string suitable for use as a
+// one-time subscribe/unsubscribe token. It is not tied to the email
+// address it will be associated with, so leaking one token does not
+// expose the others.
+func generateToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
 	}
+	sum := sha512.Sum512(raw)
+	return base64.URLEncoding.EncodeToString(sum[:]), nil
 }
 
 // creates an email data structure from a database row
@@ -43,9 +60,11 @@ func emailEntryFromRow(row *sql.Rows) (*EmailEntry, error) {
 	var email string
 	var confirmedAt int64
 	var optOut bool
+	var subToken string
+	var unsubToken string
+
+	err := row.Scan(&id, &email, &confirmedAt, &optOut, &subToken, &unsubToken)
 
-	err := row.Scan(&id, &email, &confirmedAt, &optOut)
-	
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -54,18 +73,68 @@ func emailEntryFromRow(row *sql.Rows) (*EmailEntry, error) {
 	// we're storing the time in the database as unix time, which
 	// is integers, so we need to convert time (int to time.Time)
 	t := time.Unix(confirmedAt, 0)
-	return &EmailEntry{Id: id, Email: email, ConfirmedAt: &t, OptOut: optOut}, nil
+	return &EmailEntry{
+		Id:          id,
+		Email:       email,
+		ConfirmedAt: &t,
+		OptOut:      optOut,
+		SubToken:    subToken,
+		UnsubToken:  unsubToken,
+	}, nil
 }
 
+// CreateEmail inserts a new, unconfirmed email entry with freshly generated
+// sub/unsub tokens. confirmed_at is left at 0 until the subscriber clicks
+// the confirmation link sent to sub_token's URL.
+//
+// Re-submitting an address that's already in the table is not an error:
+// if it's still pending, the sub-token is rotated and ErrPendingConfirmation
+// is returned so the caller can re-send the opt-in email; if it's already
+// confirmed, ErrAlreadyConfirmed is returned instead.
 func CreateEmail(db *sql.DB, email string) error {
-	// email will replace the '?'
-	// '0' is the confirmed_at time, and 0 indicates that the email has not been confirmed
-	// opt_out is defaulted to false
-	// the id is set automatically
-	_, err := db.Exec(`INSERT INTO
-		emails(email, confirmed_at, opt_out)
-		VALUES(?, 0, false)`, email)
-	
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return ErrInvalidEmail
+	}
+
+	existing, err := GetEmail(db, email)
+	if err != nil {
+		return err
+	}
+
+	if existing != nil {
+		if existing.ConfirmedAt.Unix() != 0 {
+			return ErrAlreadyConfirmed
+		}
+
+		subToken, err := generateToken()
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+
+		if _, err := db.Exec(`UPDATE emails SET sub_token=? WHERE email=?`, subToken, email); err != nil {
+			log.Println(err)
+			return err
+		}
+
+		return ErrPendingConfirmation
+	}
+
+	subToken, err := generateToken()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	unsubToken, err := generateToken()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	_, err = db.Exec(`INSERT INTO
+		emails(email, confirmed_at, opt_out, sub_token, unsub_token)
+		VALUES(?, 0, false, ?, ?)`, email, subToken, unsubToken)
+
 	if err != nil {
 		log.Println(err)
 		return err
@@ -77,7 +146,7 @@ func CreateEmail(db *sql.DB, email string) error {
 // function to reading an email
 func GetEmail(db *sql.DB, email string) (*EmailEntry, error) {
 	rows, err := db.Query(`
-		SELECT id, email, confirmed_at, opt_out
+		SELECT id, email, confirmed_at, opt_out, sub_token, unsub_token
 		FROM emails
 		WHERE email = ?`, email)
 
@@ -95,6 +164,59 @@ func GetEmail(db *sql.DB, email string) (*EmailEntry, error) {
 	return nil, nil
 }
 
+// ConfirmEmail marks the entry owning subToken as confirmed. It is called
+// when a subscriber follows the confirmation link from their opt-in email.
+// It returns ErrTokenNotFound if no entry has that sub_token.
+func ConfirmEmail(db *sql.DB, subToken string) error {
+	result, err := db.Exec(`
+		UPDATE emails
+		SET confirmed_at=?
+		WHERE sub_token=?`, time.Now().Unix(), subToken)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if affected == 0 {
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
+
+// UnsubscribeByToken opts an entry out of the mailing list using the
+// unsub_token mailed alongside every confirmation/campaign message, so a
+// subscriber never needs to sign in to stop receiving mail. It returns
+// ErrTokenNotFound if no entry has that unsub_token.
+func UnsubscribeByToken(db *sql.DB, unsubToken string) error {
+	result, err := db.Exec(`
+		UPDATE emails
+		SET opt_out=true
+		WHERE unsub_token=?`, unsubToken)
+
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if affected == 0 {
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
+
 // UpdateEmail is an 'upsert' function. It will create a new email if
 // is already exists. If not, it will do an 'update' operation, setting 
 // confirmed_at and op_out. So we never change the id nor email 
@@ -139,32 +261,80 @@ type GetEmailBatchQueryParams struct {
 	Count int // number of email supposed to be returned
 }
 
+// GetEmailBatch is kept for callers doing simple, offset-based pagination
+// over every non-opted-out subscriber, confirmed or not. It's now a thin wrapper over
+// IterateEmails, which scales to large tables better.
 func GetEmailBatch(db *sql.DB, params GetEmailBatchQueryParams) ([]EmailEntry, error) {
-	var empty []EmailEntry
-
-	rows, err := db.Query(`
-		SELECT id, email, confirmed_at, opt_out
-		FROM emails
-		WHERE opt_out = false
-		ORDER BY id ASC
-		LIMIT ? OFFSET ?`, params.Count, (params.Page-1)*params.Count) // the last line 'LIMIT ? OFFSET ?' is what enables pagination
-		
+	it, closeIt, err := IterateEmails(db, EmailFilter{IncludeUnconfirmed: true})
 	if err != nil {
-		log.Println(err)
-		return empty, err
+		return nil, err
 	}
-
-	defer rows.Close()
+	defer closeIt()
 
 	emails := make([]EmailEntry, 0, params.Count)
+	skip := (params.Page - 1) * params.Count
 
-	for rows.Next() {
-		email, err := emailEntryFromRow(rows)
+	for i := 0; len(emails) < params.Count; i++ {
+		entry, err := it()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			return nil, err
 		}
-		emails = append(emails, *email)
+		if i < skip {
+			continue
+		}
+		emails = append(emails, *entry)
 	}
 
 	return emails, nil
+}
+
+// EmailFilter narrows down the rows IterateEmails scans. The zero value
+// matches confirmed, non-opted-out subscribers; GetEmailBatch sets
+// IncludeUnconfirmed so it still returns every non-opted-out entry.
+type EmailFilter struct {
+	IncludeUnconfirmed bool
+	IncludeOptedOut    bool
+}
+
+// EmailIterator yields one EmailEntry per call, returning io.EOF once
+// exhausted. Unlike GetEmailBatch it holds a single *sql.Rows cursor open
+// rather than materialising a slice, so it scales to tables too large to
+// page through with OFFSET.
+type EmailIterator func() (*EmailEntry, error)
+
+// IterateEmails runs a single query matching filter and returns an
+// EmailIterator over it, along with a close func. The iterator closes its
+// underlying rows itself once it reaches io.EOF, but a caller that stops
+// pulling before EOF MUST call close to release the connection back to
+// the pool — it's always safe to call, even after EOF.
+func IterateEmails(db *sql.DB, filter EmailFilter) (EmailIterator, func() error, error) {
+	query := `SELECT id, email, confirmed_at, opt_out, sub_token, unsub_token FROM emails WHERE 1=1`
+	var queryArgs []interface{}
+
+	if !filter.IncludeUnconfirmed {
+		query += ` AND confirmed_at != 0`
+	}
+	if !filter.IncludeOptedOut {
+		query += ` AND opt_out = false`
+	}
+	query += ` ORDER BY id ASC`
+
+	rows, err := db.Query(query, queryArgs...)
+	if err != nil {
+		log.Println(err)
+		return nil, nil, err
+	}
+
+	it := func() (*EmailEntry, error) {
+		if !rows.Next() {
+			rows.Close()
+			return nil, io.EOF
+		}
+		return emailEntryFromRow(rows)
+	}
+
+	return it, rows.Close, nil
 }
\ No newline at end of file
@@ -0,0 +1,100 @@
+package mdb
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// TestGetEmailBatchReleasesConnections guards against a regression where
+// GetEmailBatch stopped pulling from its iterator as soon as Count was
+// satisfied without closing the underlying rows, leaking a pooled
+// connection on every call.
+func TestGetEmailBatchReleasesConnections(t *testing.T) {
+	db := openTestDB(t)
+
+	for i := 0; i < 5; i++ {
+		if err := CreateEmail(db, string(rune('a'+i))+"@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	params := GetEmailBatchQueryParams{Page: 1, Count: 2}
+	for i := 0; i < 10; i++ {
+		if _, err := GetEmailBatch(db, params); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if inUse := db.Stats().InUse; inUse != 0 {
+		t.Fatalf("GetEmailBatch leaked a connection: db.Stats().InUse = %v, want 0", inUse)
+	}
+}
+
+func TestIterateEmailsStopsEarlyWithoutLeaking(t *testing.T) {
+	db := openTestDB(t)
+
+	for i := 0; i < 3; i++ {
+		if err := CreateEmail(db, string(rune('a'+i))+"@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	it, closeIt, err := IterateEmails(db, EmailFilter{IncludeUnconfirmed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := it(); err != nil {
+		t.Fatal(err)
+	}
+	if err := closeIt(); err != nil {
+		t.Fatal(err)
+	}
+
+	if inUse := db.Stats().InUse; inUse != 0 {
+		t.Fatalf("IterateEmails leaked a connection after early close: db.Stats().InUse = %v, want 0", inUse)
+	}
+}
+
+func TestConfirmEmailAndUnsubscribeByTokenRejectUnknownTokens(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := ConfirmEmail(db, "no-such-token"); err != ErrTokenNotFound {
+		t.Fatalf("ConfirmEmail with unknown token returned %v, want ErrTokenNotFound", err)
+	}
+
+	if err := UnsubscribeByToken(db, "no-such-token"); err != ErrTokenNotFound {
+		t.Fatalf("UnsubscribeByToken with unknown token returned %v, want ErrTokenNotFound", err)
+	}
+
+	if err := CreateEmail(db, "a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := GetEmail(db, "a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ConfirmEmail(db, entry.SubToken); err != nil {
+		t.Fatalf("ConfirmEmail with valid token: %v", err)
+	}
+	if err := UnsubscribeByToken(db, entry.UnsubToken); err != nil {
+		t.Fatalf("UnsubscribeByToken with valid token: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package mdb
+
+import (
+	"database/sql"
+	"embed"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const migrationsTable = "mdb_migrations"
+
+// Migrate applies any pending emails-schema migrations, recording applied
+// versions in the mdb_migrations table. It's safe to call on every
+// startup: migrations that have already run are skipped.
+//
+// A database created by the old TryCreate already has the emails table
+// but no migrations table, so the very first call baselines migration
+// 0001 (and 0002, if that table already has the token columns) as applied
+// instead of trying to re-run a CREATE TABLE/ADD COLUMN that would fail
+// against the existing schema.
+func Migrate(db *sql.DB) error {
+	migrate.SetTable(migrationsTable)
+
+	exists, err := tableExists(db, "emails")
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := baselineMigration(db, "0001_create_emails.sql"); err != nil {
+			return err
+		}
+
+		hasTokens, err := columnExists(db, "emails", "sub_token")
+		if err != nil {
+			return err
+		}
+		if hasTokens {
+			if err := baselineMigration(db, "0002_add_tokens.sql"); err != nil {
+				return err
+			}
+		}
+	}
+
+	source := migrate.EmbedFileSystemMigrationSource{
+		FileSystem: migrationFiles,
+		Root:       "migrations",
+	}
+
+	_, err = migrate.Exec(db, "sqlite3", source, migrate.Up)
+	return err
+}
+
+func tableExists(db *sql.DB, table string) (bool, error) {
+	var name string
+	err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name=?`, table).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func columnExists(db *sql.DB, table string, column string) (bool, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// baselineMigration records migrationId as already applied, so Migrate
+// doesn't try to re-run a CREATE TABLE/ADD COLUMN that was already
+// executed by the old TryCreate.
+func baselineMigration(db *sql.DB, migrationId string) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + migrationsTable + ` (id TEXT PRIMARY KEY, applied_at TIMESTAMP)`); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`INSERT OR IGNORE INTO `+migrationsTable+` (id, applied_at) VALUES (?, ?)`, migrationId, time.Now())
+	return err
+}
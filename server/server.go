@@ -3,17 +3,30 @@ package main
 import (
 	"database/sql"
 	"log"
+	"mailinglist/campaign"
 	"mailinglist/jsonapi"
 	"mailinglist/mdb"
 	"sync"
+	"time"
 
 	"github.com/alexflint/go-arg"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 var args struct {
 	// We can specify the mailinglist_db in the commandline, otherwise it is set to a default
 	DbPath string `arg:"env:MAILINGLIST_DB`
 	BindJson string `arg:"env:MAILINGLIST_BIND_JSON"`
+	BaseURL string `arg:"env:MAILINGLIST_BASE_URL"`
+	CampaignRatePerSecond float64 `arg:"env:MAILINGLIST_CAMPAIGN_RATE"`
+
+	// SMTP settings for sending real mail. When SMTPHost is unset, mailers
+	// fall back to logging instead of sending.
+	SMTPHost     string `arg:"env:MAILINGLIST_SMTP_HOST" help:"SMTP server host"`
+	SMTPPort     string `arg:"env:MAILINGLIST_SMTP_PORT" help:"SMTP server port"`
+	SMTPUsername string `arg:"env:MAILINGLIST_SMTP_USERNAME"`
+	SMTPPassword string `arg:"env:MAILINGLIST_SMTP_PASSWORD"`
+	SMTPFrom     string `arg:"env:MAILINGLIST_SMTP_FROM" help:"From address used for sent mail"`
 }
 
 func main() {
@@ -25,6 +38,12 @@ func main() {
 	if args.BindJson == "" {
 		args.BindJson = ":8080" // default port
 	}
+	if args.BaseURL == "" {
+		args.BaseURL = "http://localhost:8080" // default base URL used to build confirm/unsubscribe links
+	}
+	if args.CampaignRatePerSecond == 0 {
+		args.CampaignRatePerSecond = 10 // default campaign send rate
+	}
 
 	log.Printf("using database '%v'\n", args.DbPath)
 	db, err := sql.Open("sqlite3", args.DbPath)
@@ -34,17 +53,43 @@ func main() {
 	}
 	defer db.Close()
 
-	mdb.TryCreate(db)
+	if err := mdb.Migrate(db); err != nil {
+		log.Fatal(err)
+	}
+	if err := campaign.Migrate(db); err != nil {
+		log.Fatal(err)
+	}
+
+	var mailer jsonapi.Mailer
+	if args.SMTPHost == "" {
+		mailer = jsonapi.NewLogMailer(args.BaseURL)
+	} else {
+		addr := args.SMTPHost + ":" + args.SMTPPort
+		mailer = jsonapi.NewSMTPMailer(addr, args.SMTPFrom, args.BaseURL, args.SMTPUsername, args.SMTPPassword, args.SMTPHost)
+	}
+
+	var campaignMailer campaign.Mailer
+	if args.SMTPHost == "" {
+		campaignMailer = campaign.NewLogMailer()
+	} else {
+		addr := args.SMTPHost + ":" + args.SMTPPort
+		campaignMailer = campaign.NewSMTPMailer(addr, args.SMTPFrom, args.SMTPUsername, args.SMTPPassword, args.SMTPHost)
+	}
 
 	var wg sync.WaitGroup
 
 	wg.Add(1)
 	go func() {
 		log.Printf("starting JSON API server...\n")
-		jsonapi.Serve(db, args.BindJson)
+		jsonapi.Serve(db, args.BindJson, mailer)
 		wg.Done()
 	}()
 
+	go func() {
+		log.Printf("starting campaign worker...\n")
+		campaign.StartWorker(db, campaignMailer, args.BaseURL, args.CampaignRatePerSecond, 10*time.Second)
+	}()
+
 	wg.Wait()
 }
 